@@ -10,6 +10,10 @@ import (
 	"sourcegraph.com/sourcegraph/sourcegraph/pkg/gitserver/protocol"
 )
 
+// repositoryMirrorInfoResolver has no archive URL field: gitserver has no
+// /archive handler, and httpapi has no route that verifies a signed archive
+// URL before proxying to one.
+
 func (r *repositoryResolver) MirrorInfo() *repositoryMirrorInfoResolver {
 	return &repositoryMirrorInfoResolver{repository: r}
 }
@@ -53,6 +53,65 @@ type Client struct {
 
 	Addrs   []string
 	NoCreds bool
+
+	// Sharder decides, for each repo, which of Addrs is responsible for it.
+	// If nil, rendezvousSharder{} is used.
+	Sharder Sharder
+}
+
+// Sharder picks the gitserver address(es) responsible for a repository out
+// of a set of candidate addresses.
+type Sharder interface {
+	// Pick returns addrs reordered so that the most preferred address for
+	// repoURI comes first. Callers that can tolerate hitting a stale
+	// replica (e.g. read-only RPCs) may fall back to subsequent addresses
+	// on error.
+	Pick(repoURI string, addrs []string) []string
+}
+
+// rendezvousSharder implements Sharder using Highest Random Weight (a.k.a.
+// rendezvous) hashing: for each candidate address it computes a weight from
+// hash64(repoURI, addr) and orders addresses by descending weight. Unlike
+// `hash(repoURI) % len(addrs)`, adding or removing a single address only
+// reshuffles about 1/len(addrs) of repos onto a new owner instead of nearly
+// all of them.
+type rendezvousSharder struct{}
+
+func (rendezvousSharder) Pick(repoURI string, addrs []string) []string {
+	type weightedAddr struct {
+		addr   string
+		weight uint64
+	}
+	weighted := make([]weightedAddr, len(addrs))
+	for i, addr := range addrs {
+		weighted[i] = weightedAddr{addr: addr, weight: hash64(repoURI + "\x00" + addr)}
+	}
+	sort.Slice(weighted, func(i, j int) bool { return weighted[i].weight > weighted[j].weight })
+
+	picked := make([]string, len(weighted))
+	for i, w := range weighted {
+		picked[i] = w.addr
+	}
+	return picked
+}
+
+func hash64(s string) uint64 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint64(sum[:])
+}
+
+// sharder returns c.Sharder, defaulting to rendezvous hashing.
+func (c *Client) sharder() Sharder {
+	if c.Sharder != nil {
+		return c.Sharder
+	}
+	return rendezvousSharder{}
+}
+
+// shardAddrs returns c.Addrs ordered by preference for repoURI, most
+// preferred first.
+func (c *Client) shardAddrs(repoURI string) []string {
+	return c.sharder().Pick(repoURI, c.Addrs)
 }
 
 func (c *Cmd) sendExec(ctx context.Context) (_ io.ReadCloser, _ http.Header, errRes error) {
@@ -76,9 +135,7 @@ func (c *Cmd) sendExec(ctx context.Context) (_ io.ReadCloser, _ http.Header, err
 		return nil, nil, err
 	}
 
-	sum := md5.Sum([]byte(repoURI))
-	serverIndex := binary.BigEndian.Uint64(sum[:]) % uint64(len(c.client.Addrs))
-	addr := c.client.Addrs[serverIndex]
+	addr := c.client.shardAddrs(repoURI)[0]
 
 	req := &protocol.ExecRequest{
 		Repo:           repoURI,
@@ -241,16 +298,18 @@ func (c *Client) ListCloning(ctx context.Context) ([]string, error) {
 }
 
 // doListMulti calls the /list endpoint with the given URL suffix on the gitservers whose
-// addresses are specified. The results from all of the gitservers are merged.
+// addresses are specified. The results from all of the gitservers are merged, deduplicating
+// by repo so that a repo transiently cloned on two servers during a rebalance is only
+// counted once.
 func doListMulti(ctx context.Context, urlSuffix string, addrs []string) ([]string, error) {
 	if len(addrs) == 1 {
 		return doListOne(ctx, urlSuffix, addrs[0])
 	}
 
 	var (
-		mu           sync.Mutex
-		err          error
-		combinedList []string
+		mu   sync.Mutex
+		err  error
+		seen = make(map[string]struct{})
 	)
 	var wg sync.WaitGroup
 	ctx, cancel := context.WithCancel(ctx)
@@ -259,7 +318,6 @@ func doListMulti(ctx context.Context, urlSuffix string, addrs []string) ([]strin
 		wg.Add(1)
 		go func(addr string) {
 			defer wg.Done()
-			var listErr error
 			list, listErr := doListOne(ctx, urlSuffix, addr)
 			mu.Lock()
 			defer mu.Unlock()
@@ -267,10 +325,17 @@ func doListMulti(ctx context.Context, urlSuffix string, addrs []string) ([]strin
 				cancel()
 				err = listErr
 			}
-			combinedList = append(combinedList, list...)
+			for _, repo := range list {
+				seen[repo] = struct{}{}
+			}
 		}(addr)
 	}
 	wg.Wait()
+
+	combinedList := make([]string, 0, len(seen))
+	for repo := range seen {
+		combinedList = append(combinedList, repo)
+	}
 	sort.Strings(combinedList)
 	return combinedList, err
 }
@@ -291,13 +356,23 @@ func (c *Client) EnqueueRepoUpdate(ctx context.Context, repo string) error {
 	req := &protocol.RepoUpdateRequest{
 		Repo: repo,
 	}
-	_, err := c.httpPost(ctx, c.Addrs[0], "enqueue-repo-update", req)
+	// Pinned to the primary shard: this is a write (it enqueues a clone/fetch
+	// on that shard), so it must not be retried against a different replica.
+	addr := c.shardAddrs(repo)[0]
+	_, err := c.httpPost(ctx, addr, "enqueue-repo-update", req)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// Push-mirror replication (chunk0-2: Client.ConfigureMirror/RunMirrorPush, a
+// mirrorInfo.lastPushError GraphQL field, and src_gitserver_mirror_push_*
+// counters) is deferred. It needs a gitserver-side push-mirror runtime (a
+// per-repo watcher, refs diffing, retry with backoff) and the protocol
+// request/response types for it, neither of which exist in this tree, so no
+// client stub or dashboard panel for it is shipped here.
+
 // IsRepoCloneable returns true if the repository is cloneable.
 func (c *Client) IsRepoCloneable(ctx context.Context, repo string) (bool, error) {
 	req := &protocol.IsRepoCloneableRequest{
@@ -318,7 +393,7 @@ func (c *Client) IsRepoCloned(ctx context.Context, repo string) (bool, error) {
 	req := &protocol.IsRepoClonedRequest{
 		Repo: repo,
 	}
-	resp, err := c.httpPost(ctx, c.Addrs[0], "is-repo-cloned", req)
+	resp, err := c.httpPostRepoWithFallback(ctx, repo, "is-repo-cloned", req)
 	if err != nil {
 		return false, err
 	}
@@ -337,7 +412,7 @@ func (c *Client) RepoInfo(ctx context.Context, repo string) (*protocol.RepoInfoR
 	req := &protocol.RepoInfoRequest{
 		Repo: repo,
 	}
-	resp, err := c.httpPost(ctx, c.Addrs[0], "repo", req)
+	resp, err := c.httpPostRepoWithFallback(ctx, repo, "repo", req)
 	if err != nil {
 		return nil, err
 	}
@@ -403,11 +478,24 @@ func (c *Client) httpPost(ctx context.Context, addr string, method string, paylo
 	}
 }
 
+// httpPostRepoWithFallback posts payload to the gitserver shard responsible
+// for repoURI, retrying against the next-most-preferred replica if the
+// request fails outright (e.g. connection refused). Only safe for read-only
+// RPCs, since a fallback request may land on a stale replica.
+func (c *Client) httpPostRepoWithFallback(ctx context.Context, repoURI, method string, payload interface{}) (resp *http.Response, err error) {
+	addrs := c.shardAddrs(repoURI)
+	for i, addr := range addrs {
+		resp, err = c.httpPost(ctx, addr, method, payload)
+		if err == nil || i == len(addrs)-1 {
+			return resp, err
+		}
+	}
+	return resp, err
+}
+
 func (c *Client) UploadPack(repoURI string, w http.ResponseWriter, r *http.Request) {
 	repoURI = protocol.NormalizeRepo(repoURI)
-	sum := md5.Sum([]byte(repoURI))
-	serverIndex := binary.BigEndian.Uint64(sum[:]) % uint64(len(c.Addrs))
-	addr := c.Addrs[serverIndex]
+	addr := c.shardAddrs(repoURI)[0]
 
 	u, err := url.Parse("http://" + addr + "/upload-pack?repo=" + url.QueryEscape(repoURI))
 	if err != nil {
@@ -421,3 +509,7 @@ func (c *Client) UploadPack(repoURI string, w http.ResponseWriter, r *http.Reque
 		},
 	}).ServeHTTP(w, r)
 }
+
+// Client has no repository archive (tarball) method: gitserver has no
+// /archive handler that runs git archive, and no httpapi route or GraphQL
+// resolver hands out archive URLs.
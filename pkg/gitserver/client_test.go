@@ -0,0 +1,99 @@
+package gitserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRendezvousSharderPick(t *testing.T) {
+	addrs := []string{"gitserver-0:3178", "gitserver-1:3178", "gitserver-2:3178", "gitserver-3:3178"}
+	sharder := rendezvousSharder{}
+	repos := []string{"github.com/foo/bar", "github.com/baz/qux", "gitlab.com/a/b", "github.com/sourcegraph/sourcegraph"}
+
+	for _, repo := range repos {
+		order := sharder.Pick(repo, addrs)
+		if len(order) != len(addrs) {
+			t.Fatalf("Pick(%q) returned %d addrs, want %d", repo, len(order), len(addrs))
+		}
+		seen := make(map[string]bool, len(order))
+		for _, a := range order {
+			seen[a] = true
+		}
+		for _, a := range addrs {
+			if !seen[a] {
+				t.Fatalf("Pick(%q) dropped address %q", repo, a)
+			}
+		}
+
+		if got := sharder.Pick(repo, addrs)[0]; got != order[0] {
+			t.Fatalf("Pick(%q) is not stable across calls: got %q then %q", repo, order[0], got)
+		}
+
+		// HRW's defining property: removing an address that isn't the
+		// current primary must not change the primary.
+		var removed string
+		withoutOne := make([]string, 0, len(addrs)-1)
+		for _, a := range addrs {
+			if a != order[0] && removed == "" {
+				removed = a
+				continue
+			}
+			withoutOne = append(withoutOne, a)
+		}
+		if got := sharder.Pick(repo, withoutOne)[0]; got != order[0] {
+			t.Errorf("Pick(%q) primary changed from %q to %q after removing unrelated address %q", repo, order[0], got, removed)
+		}
+	}
+}
+
+// fixedOrderSharder returns addrs unmodified, so tests can control exactly
+// which address is tried first.
+type fixedOrderSharder struct{}
+
+func (fixedOrderSharder) Pick(repoURI string, addrs []string) []string {
+	return addrs
+}
+
+func TestHttpPostRepoWithFallback(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ok.Close()
+
+	// A server we immediately close guarantees the first address is
+	// unreachable, forcing httpPostRepoWithFallback to fall through to addrs[1].
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	downAddr := down.Listener.Addr().String()
+	down.Close()
+
+	c := &Client{
+		Addrs:   []string{downAddr, ok.Listener.Addr().String()},
+		Sharder: fixedOrderSharder{},
+	}
+
+	resp, err := c.httpPostRepoWithFallback(context.Background(), "github.com/foo/bar", "is-repo-cloned", map[string]string{"Repo": "github.com/foo/bar"})
+	if err != nil {
+		t.Fatalf("expected fallback request to succeed, got error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestHttpPostRepoWithFallbackAllDown(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	downAddr := down.Listener.Addr().String()
+	down.Close()
+
+	c := &Client{
+		Addrs:   []string{downAddr},
+		Sharder: fixedOrderSharder{},
+	}
+
+	if _, err := c.httpPostRepoWithFallback(context.Background(), "github.com/foo/bar", "is-repo-cloned", map[string]string{"Repo": "github.com/foo/bar"}); err == nil {
+		t.Fatal("expected an error when every replica is unreachable, got nil")
+	}
+}